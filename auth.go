@@ -0,0 +1,135 @@
+package gochat
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidToken is returned by an Authenticator when a connect token is
+// missing, malformed, unverifiable, or expired.
+var ErrInvalidToken = errors.New("gochat: invalid auth token")
+
+// AuthToken is the identity and permission set carried by a verified
+// connect token. Authenticate populates this from the client-supplied
+// token instead of trusting the connect message's User field.
+type AuthToken struct {
+	UserID         string            `json:"userId"`
+	UserName       string            `json:"userName"`
+	AdditionalInfo map[string]string `json:"additionalInfo,omitempty"`
+	Permissions    []string          `json:"permissions,omitempty"`
+	ExpiresAt      int64             `json:"expiresAt"`
+}
+
+// Authenticator verifies the token carried by a client's first
+// CommandUserConnect message.
+type Authenticator interface {
+	Authenticate(token string) (*AuthToken, error)
+}
+
+// HMACAuthenticator is the default Authenticator. A token is
+// base64url(payload) + "." + base64url(signature), where payload is the
+// JSON encoding of AuthToken and signature is HMAC-SHA256, keyed with
+// secret, over the base64url-encoded payload string.
+type HMACAuthenticator struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewHMACAuthenticator returns an HMACAuthenticator that verifies tokens
+// signed with secret and rejects any whose ExpiresAt has passed; a token
+// with ExpiresAt 0 never expires. ttl is used by IssueToken when minting
+// new tokens.
+func NewHMACAuthenticator(secret string, ttl time.Duration) *HMACAuthenticator {
+	return &HMACAuthenticator{
+		secret: []byte(secret),
+		ttl:    ttl,
+	}
+}
+
+// IssueToken signs a token for userID/userName/permissions, valid for the
+// authenticator's TTL from now. It's a convenience for trusted server-side
+// code (e.g. a login endpoint) that wants to mint tokens for this scheme.
+func (auth *HMACAuthenticator) IssueToken(userID, userName string, additionalInfo map[string]string, permissions []string) (string, error) {
+	payload, err := json.Marshal(AuthToken{
+		UserID:         userID,
+		UserName:       userName,
+		AdditionalInfo: additionalInfo,
+		Permissions:    permissions,
+		ExpiresAt:      time.Now().Add(auth.ttl).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("gochat: encode auth token: %w", err)
+	}
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	encodedSignature := base64.RawURLEncoding.EncodeToString(auth.sign(encodedPayload))
+
+	return encodedPayload + "." + encodedSignature, nil
+}
+
+func (auth *HMACAuthenticator) Authenticate(token string) (*AuthToken, error) {
+	encodedPayload, encodedSignature, ok := splitToken(token)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, auth.sign(encodedPayload)) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var decoded AuthToken
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if decoded.ExpiresAt != 0 && time.Now().Unix() > decoded.ExpiresAt {
+		return nil, fmt.Errorf("gochat: token expired: %w", ErrInvalidToken)
+	}
+
+	return &decoded, nil
+}
+
+func (auth *HMACAuthenticator) sign(encodedPayload string) []byte {
+	mac := hmac.New(sha256.New, auth.secret)
+	mac.Write([]byte(encodedPayload))
+
+	return mac.Sum(nil)
+}
+
+// hasPermission reports whether the user is allowed to perform permission.
+// When the server has no Authenticator installed, permissions aren't
+// enforced and every action is allowed.
+func (user *User) hasPermission(permission string) bool {
+	if user.server.authenticator == nil {
+		return true
+	}
+
+	for _, granted := range user.Permissions {
+		if granted == permission || granted == "admin" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func splitToken(token string) (payload, signature string, ok bool) {
+	for i := len(token) - 1; i >= 0; i-- {
+		if token[i] == '.' {
+			return token[:i], token[i+1:], true
+		}
+	}
+
+	return "", "", false
+}
@@ -0,0 +1,19 @@
+package gochat
+
+// Broker lets multiple gochat server processes share channels and groups
+// by publishing and subscribing to topics on an external pub/sub system,
+// instead of only fanning messages out to the local process's in-memory
+// user.send channels.
+type Broker interface {
+	// Publish sends payload to every subscriber of topic, on this process
+	// and any other one connected to the same backing pub/sub system.
+	Publish(topic string, payload []byte) error
+
+	// Subscribe returns a channel that receives every payload published
+	// to topic, including this process's own publishes.
+	Subscribe(topic string) (<-chan []byte, error)
+}
+
+func channelTopic(channelID string) string {
+	return "gochat.channel." + channelID
+}
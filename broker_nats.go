@@ -0,0 +1,31 @@
+package gochat
+
+import "github.com/nats-io/nats.go"
+
+// NATSBroker is a Broker backed by NATS core pub/sub.
+type NATSBroker struct {
+	conn *nats.Conn
+}
+
+// NewNATSBroker returns a Broker that publishes and subscribes over an
+// already-connected NATS connection.
+func NewNATSBroker(conn *nats.Conn) *NATSBroker {
+	return &NATSBroker{conn: conn}
+}
+
+func (broker *NATSBroker) Publish(topic string, payload []byte) error {
+	return broker.conn.Publish(topic, payload)
+}
+
+func (broker *NATSBroker) Subscribe(topic string) (<-chan []byte, error) {
+	out := make(chan []byte, 256)
+
+	_, err := broker.conn.Subscribe(topic, func(msg *nats.Msg) {
+		out <- msg.Data
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
@@ -0,0 +1,44 @@
+package gochat
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBroker is a Broker backed by Redis Pub/Sub.
+type RedisBroker struct {
+	client *redis.Client
+	ctx    context.Context
+}
+
+// NewRedisBroker returns a Broker that publishes and subscribes over an
+// already-connected Redis client.
+func NewRedisBroker(client *redis.Client) *RedisBroker {
+	return &RedisBroker{
+		client: client,
+		ctx:    context.Background(),
+	}
+}
+
+func (broker *RedisBroker) Publish(topic string, payload []byte) error {
+	return broker.client.Publish(broker.ctx, topic, payload).Err()
+}
+
+func (broker *RedisBroker) Subscribe(topic string) (<-chan []byte, error) {
+	pubsub := broker.client.Subscribe(broker.ctx, topic)
+	if _, err := pubsub.Receive(broker.ctx); err != nil {
+		return nil, err
+	}
+
+	out := make(chan []byte, 256)
+	go func() {
+		defer close(out)
+
+		for message := range pubsub.Channel() {
+			out <- []byte(message.Payload)
+		}
+	}()
+
+	return out, nil
+}
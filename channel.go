@@ -0,0 +1,271 @@
+package gochat
+
+import (
+	"encoding/json"
+	"log"
+)
+
+type Channel struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	AdditionalInfo map[string]string `json:"additionalInfo,omitempty"`
+
+	users  map[string]*User
+	groups map[string]*Group
+
+	registerUser    chan *User
+	unregisterUser  chan *User
+	registerGroup   chan *Group
+	unregisterGroup chan *Group
+
+	// findUser, findGroup, and roster let other goroutines read users and
+	// groups safely: channel.users and channel.groups are otherwise only
+	// ever touched from the Channel.Run goroutine, which isn't guarded by
+	// a lock, so every cross-goroutine read is routed through Run instead.
+	findUser  chan *userQuery
+	findGroup chan *groupQuery
+	roster    chan chan []*User
+
+	// broker and inbound are set by subscribeBroker when the server has a
+	// Broker installed, so messages published by any node (this one
+	// included) reach this node's local users.
+	broker  Broker
+	inbound <-chan []byte
+}
+
+type userQuery struct {
+	id    string
+	reply chan *User
+}
+
+type groupQuery struct {
+	id    string
+	reply chan *Group
+}
+
+func NewChannel(id, name string, additionalInfo map[string]string) *Channel {
+	return &Channel{
+		ID:             id,
+		Name:           name,
+		AdditionalInfo: additionalInfo,
+
+		users:  make(map[string]*User),
+		groups: make(map[string]*Group),
+
+		registerUser:    make(chan *User),
+		unregisterUser:  make(chan *User),
+		registerGroup:   make(chan *Group),
+		unregisterGroup: make(chan *Group),
+
+		findUser:  make(chan *userQuery),
+		findGroup: make(chan *groupQuery),
+		roster:    make(chan chan []*User),
+	}
+}
+
+func (channel *Channel) Run() {
+	for {
+		select {
+		case user := <-channel.registerUser:
+			channel.handleRegisterUser(user)
+
+		case user := <-channel.unregisterUser:
+			channel.handleUnregisterUser(user)
+
+		case group := <-channel.registerGroup:
+			channel.handleRegisterGroup(group)
+
+		case group := <-channel.unregisterGroup:
+			channel.handleUnregisterGroup(group)
+
+		case query := <-channel.findUser:
+			query.reply <- channel.lookupUserByID(query.id)
+
+		case query := <-channel.findGroup:
+			query.reply <- channel.lookupGroupByID(query.id)
+
+		case reply := <-channel.roster:
+			reply <- channel.snapshotRoster()
+
+		case payload, ok := <-channel.inbound:
+			if ok {
+				channel.handleBrokerMessage(payload)
+			}
+		}
+	}
+}
+
+// subscribeBroker subscribes the channel to its broker topic, so messages
+// published by any node hosting users in this channel (this one included)
+// are delivered to this node's local users. It must be called before Run,
+// from the Server.Run goroutine that owns channel registration.
+func (channel *Channel) subscribeBroker(broker Broker) error {
+	inbound, err := broker.Subscribe(channelTopic(channel.ID))
+	if err != nil {
+		return err
+	}
+
+	channel.broker = broker
+	channel.inbound = inbound
+
+	return nil
+}
+
+// publish sends message to the channel's broker topic instead of handing
+// it to local users directly, so every node hosting this channel —
+// including this one, via its own subscription — delivers it. The wire
+// format between nodes is always JSON, independent of any one client's
+// negotiated codec, since handleBrokerMessage re-encodes per local
+// recipient on the way back out.
+func (channel *Channel) publish(message *Message) error {
+	if channel.broker == nil {
+		return nil
+	}
+
+	encoded, err := message.encode(JSONCodec{})
+	if err != nil {
+		return err
+	}
+
+	return channel.broker.Publish(channelTopic(channel.ID), encoded)
+}
+
+// handleBrokerMessage fans a message received from the broker out to this
+// node's local users: the direct target or group members connected to
+// this process. It runs on the Channel.Run goroutine, the same one that
+// owns channel.users and channel.groups, so reading them here is safe.
+func (channel *Channel) handleBrokerMessage(payload []byte) {
+	var message Message
+	if err := json.Unmarshal(payload, &message); err != nil {
+		log.Printf("Error decoding broker message for channel %s: %s", channel.ID, err)
+		return
+	}
+
+	if message.Target == nil {
+		return
+	}
+
+	switch message.Target.Type {
+	case TypeTargetDirect:
+		if message.Target.User == nil {
+			return
+		}
+
+		if localUser, ok := channel.users[message.Target.User.ID]; ok {
+			localUser.deliver(&message)
+		}
+
+	case TypeTargetGroup:
+		if message.Target.Group == nil {
+			return
+		}
+
+		group, ok := channel.groups[message.Target.Group.ID]
+		if !ok {
+			return
+		}
+
+		for _, localUser := range group.Roster() {
+			if message.User == nil || localUser.ID != message.User.ID {
+				localUser.deliver(&message)
+			}
+		}
+	}
+}
+
+func (channel *Channel) handleRegisterUser(user *User) {
+	channel.users[user.ID] = user
+	channel.broadcastPresence(user, TypePresenceJoin, MessagePresenceJoined)
+}
+
+func (channel *Channel) handleUnregisterUser(user *User) {
+	delete(channel.users, user.ID)
+	channel.broadcastPresence(user, TypePresenceLeave, MessagePresenceLeft)
+}
+
+// broadcastPresence notifies the rest of the channel's members that user
+// joined or left. It runs on the Channel.Run goroutine, the same one that
+// owns channel.users, so ranging over the map here is safe.
+func (channel *Channel) broadcastPresence(user *User, presenceType, text string) {
+	message := &Message{
+		Command: CommandPresenceUpdate,
+		User:    user,
+		Message: &MessageInfo{Type: presenceType, Text: text},
+	}
+
+	for _, member := range channel.users {
+		if member.ID == user.ID {
+			continue
+		}
+
+		member.deliver(message)
+	}
+}
+
+// Roster returns a snapshot of the channel's currently connected users, for
+// external code (e.g. a UI) to render presence. Safe to call from any
+// goroutine: the snapshot is built on the Channel.Run goroutine that owns
+// channel.users.
+func (channel *Channel) Roster() []*User {
+	reply := make(chan []*User)
+	channel.roster <- reply
+	return <-reply
+}
+
+// snapshotRoster builds the Roster snapshot. It must only run on the
+// Channel.Run goroutine.
+func (channel *Channel) snapshotRoster() []*User {
+	users := make([]*User, 0, len(channel.users))
+	for _, user := range channel.users {
+		users = append(users, user)
+	}
+
+	return users
+}
+
+func (channel *Channel) handleRegisterGroup(group *Group) {
+	if _, ok := channel.groups[group.ID]; !ok {
+		channel.groups[group.ID] = group
+	}
+}
+
+func (channel *Channel) handleUnregisterGroup(group *Group) {
+	delete(channel.groups, group.ID)
+}
+
+// findUserByID looks up a connected user by ID. Safe to call from any
+// goroutine: the lookup runs on the Channel.Run goroutine that owns
+// channel.users.
+func (channel *Channel) findUserByID(userID string) *User {
+	reply := make(chan *User)
+	channel.findUser <- &userQuery{id: userID, reply: reply}
+	return <-reply
+}
+
+// lookupUserByID is the unguarded map read behind findUserByID. It must
+// only run on the Channel.Run goroutine.
+func (channel *Channel) lookupUserByID(userID string) *User {
+	if user, ok := channel.users[userID]; ok {
+		return user
+	}
+
+	return nil
+}
+
+// findGroupByID looks up a group by ID. Safe to call from any goroutine:
+// the lookup runs on the Channel.Run goroutine that owns channel.groups.
+func (channel *Channel) findGroupByID(groupID string) *Group {
+	reply := make(chan *Group)
+	channel.findGroup <- &groupQuery{id: groupID, reply: reply}
+	return <-reply
+}
+
+// lookupGroupByID is the unguarded map read behind findGroupByID. It must
+// only run on the Channel.Run goroutine.
+func (channel *Channel) lookupGroupByID(groupID string) *Group {
+	if group, ok := channel.groups[groupID]; ok {
+		return group
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package gochat
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	SubprotocolJSON     = "gochat.json.v1"
+	SubprotocolMsgPack  = "gochat.msgpack.v1"
+	SubprotocolProtobuf = "gochat.proto.v1"
+)
+
+// Codec encodes and decodes a Message for the wire. The codec in use for a
+// connection is picked once, from the WebSocket subprotocol negotiated
+// during the handshake, and used for every frame on that connection.
+type Codec interface {
+	Marshal(message *Message) ([]byte, error)
+	Unmarshal(data []byte, message *Message) error
+
+	// FrameType is the gorilla/websocket frame type (TextMessage or
+	// BinaryMessage) this codec's encoded payloads should be sent as.
+	FrameType() int
+}
+
+// NegotiateCodec returns the Codec for a negotiated WebSocket subprotocol,
+// falling back to JSONCodec for an empty or unrecognized subprotocol so
+// older clients that don't request one keep working.
+func NegotiateCodec(subprotocol string) Codec {
+	switch subprotocol {
+	case SubprotocolMsgPack:
+		return MsgPackCodec{}
+	case SubprotocolProtobuf:
+		return ProtobufCodec{}
+	default:
+		return JSONCodec{}
+	}
+}
+
+// JSONCodec is the original, default wire format.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(message *Message) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (JSONCodec) Unmarshal(data []byte, message *Message) error {
+	return json.Unmarshal(data, message)
+}
+
+func (JSONCodec) FrameType() int {
+	return websocket.TextMessage
+}
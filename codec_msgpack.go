@@ -0,0 +1,22 @@
+package gochat
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgPackCodec is a smaller, faster binary alternative to JSONCodec,
+// negotiated via the SubprotocolMsgPack subprotocol.
+type MsgPackCodec struct{}
+
+func (MsgPackCodec) Marshal(message *Message) ([]byte, error) {
+	return msgpack.Marshal(message)
+}
+
+func (MsgPackCodec) Unmarshal(data []byte, message *Message) error {
+	return msgpack.Unmarshal(data, message)
+}
+
+func (MsgPackCodec) FrameType() int {
+	return websocket.BinaryMessage
+}
@@ -0,0 +1,162 @@
+package gochat
+
+import (
+	"github.com/gorilla/websocket"
+
+	"github.com/tukangremot/gochat/internal/pb"
+)
+
+// ProtobufCodec is the most compact wire format, negotiated via the
+// SubprotocolProtobuf subprotocol. It marshals through the internal/pb.Message
+// type; see internal/pb/message.proto for the schema and internal/pb's
+// package doc for why that package hand-implements the wire format instead
+// of depending on protoc-generated code.
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Marshal(message *Message) ([]byte, error) {
+	return messageToProto(message).Marshal()
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, message *Message) error {
+	var wire pb.Message
+	if err := wire.Unmarshal(data); err != nil {
+		return err
+	}
+
+	messageFromProto(&wire, message)
+
+	return nil
+}
+
+func (ProtobufCodec) FrameType() int {
+	return websocket.BinaryMessage
+}
+
+func messageToProto(message *Message) *pb.Message {
+	wire := &pb.Message{
+		Command:  message.Command,
+		Token:    message.Token,
+		Sequence: message.Sequence,
+		Since:    message.Since,
+	}
+
+	if message.User != nil {
+		wire.User = userToProto(message.User)
+	}
+	if message.Channel != nil {
+		wire.Channel = &pb.Channel{
+			Id:             message.Channel.ID,
+			Name:           message.Channel.Name,
+			AdditionalInfo: message.Channel.AdditionalInfo,
+		}
+	}
+	if message.Group != nil {
+		wire.Group = &pb.Group{
+			Id:             message.Group.ID,
+			Name:           message.Group.Name,
+			AdditionalInfo: message.Group.AdditionalInfo,
+		}
+	}
+	if message.Target != nil {
+		wire.Target = &pb.Target{Type: message.Target.Type}
+		if message.Target.User != nil {
+			wire.Target.User = userToProto(message.Target.User)
+		}
+		if message.Target.Group != nil {
+			wire.Target.Group = &pb.Group{
+				Id:             message.Target.Group.ID,
+				Name:           message.Target.Group.Name,
+				AdditionalInfo: message.Target.Group.AdditionalInfo,
+			}
+		}
+	}
+	if message.Message != nil {
+		wire.Message = &pb.MessageInfo{Type: message.Message.Type, Text: message.Message.Text}
+	}
+	if message.Response != nil {
+		wire.Response = &pb.ResponseInfo{
+			Status:  message.Response.Status,
+			Message: message.Response.Message,
+			Code:    message.Response.Code,
+		}
+	}
+	for _, user := range message.Users {
+		wire.Users = append(wire.Users, userToProto(user))
+	}
+
+	return wire
+}
+
+func userToProto(user *User) *pb.User {
+	return &pb.User{
+		Id:             user.ID,
+		Name:           user.Name,
+		AdditionalInfo: user.AdditionalInfo,
+		Presence:       user.Presence,
+		Permissions:    user.Permissions,
+	}
+}
+
+func userFromProto(wire *pb.User) *User {
+	if wire == nil {
+		return nil
+	}
+
+	return &User{
+		ID:             wire.Id,
+		Name:           wire.Name,
+		AdditionalInfo: wire.AdditionalInfo,
+		Presence:       wire.Presence,
+		Permissions:    wire.Permissions,
+	}
+}
+
+func messageFromProto(wire *pb.Message, message *Message) {
+	message.Command = wire.Command
+	message.Token = wire.Token
+	message.Sequence = wire.Sequence
+	message.Since = wire.Since
+	message.User = userFromProto(wire.User)
+
+	if wire.Channel != nil {
+		message.Channel = &Channel{
+			ID:             wire.Channel.Id,
+			Name:           wire.Channel.Name,
+			AdditionalInfo: wire.Channel.AdditionalInfo,
+		}
+	}
+	if wire.Group != nil {
+		message.Group = &Group{
+			ID:             wire.Group.Id,
+			Name:           wire.Group.Name,
+			AdditionalInfo: wire.Group.AdditionalInfo,
+		}
+	}
+	if wire.Target != nil {
+		message.Target = &TargetInfo{
+			Type:  wire.Target.Type,
+			User:  userFromProto(wire.Target.User),
+			Group: nil,
+		}
+		if wire.Target.Group != nil {
+			message.Target.Group = &Group{
+				ID:             wire.Target.Group.Id,
+				Name:           wire.Target.Group.Name,
+				AdditionalInfo: wire.Target.Group.AdditionalInfo,
+			}
+		}
+	}
+	if wire.Message != nil {
+		message.Message = &MessageInfo{Type: wire.Message.Type, Text: wire.Message.Text}
+	}
+	if wire.Response != nil {
+		message.Response = &ResponseInfo{
+			Status:  wire.Response.Status,
+			Message: wire.Response.Message,
+			Code:    wire.Response.Code,
+		}
+	}
+	for _, user := range wire.Users {
+		message.Users = append(message.Users, userFromProto(user))
+	}
+}
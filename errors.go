@@ -0,0 +1,89 @@
+package gochat
+
+import "github.com/gorilla/websocket"
+
+// Error codes let a client branch on the kind of failure and localize its
+// own copy, rather than matching on ResponseInfo.Message text.
+const (
+	ErrorCodeProtocol   = "protocol_error"
+	ErrorCodeInvalid    = "invalid_payload"
+	ErrorCodePermission = "permission_denied"
+	ErrorCodeNotFound   = "not_found"
+	ErrorCodeInternal   = "internal_error"
+)
+
+type (
+	// ProtocolError indicates the client sent a frame the server couldn't
+	// decode, or one that otherwise violates the wire protocol.
+	ProtocolError struct{ Message string }
+
+	// UserError indicates a well-formed request the server won't act on,
+	// e.g. a payload missing a required field.
+	UserError struct{ Message string }
+
+	// PermissionError indicates the user isn't allowed to perform the
+	// requested action.
+	PermissionError struct{ Message string }
+
+	// NotFoundError indicates the request targeted a user, group, or
+	// channel that doesn't exist, or isn't reachable from here.
+	NotFoundError struct{ Message string }
+)
+
+func (err *ProtocolError) Error() string   { return err.Message }
+func (err *UserError) Error() string       { return err.Message }
+func (err *PermissionError) Error() string { return err.Message }
+func (err *NotFoundError) Error() string   { return err.Message }
+
+// errorCode returns the ErrorCode identifying err's kind, for inclusion in
+// a ResponseInfo sent to the client.
+func errorCode(err error) string {
+	switch err.(type) {
+	case *ProtocolError:
+		return ErrorCodeProtocol
+	case *UserError:
+		return ErrorCodeInvalid
+	case *PermissionError:
+		return ErrorCodePermission
+	case *NotFoundError:
+		return ErrorCodeNotFound
+	default:
+		return ErrorCodeInternal
+	}
+}
+
+// setError fills in message.Response from err, replacing the ad-hoc
+// ResponseInfo{Status: false, Message: ...} literals previously built by
+// hand at every failure site.
+func (message *Message) setError(err error) {
+	message.Response = &ResponseInfo{
+		Status:  false,
+		Message: err.Error(),
+		Code:    errorCode(err),
+	}
+}
+
+// sendError enqueues a standalone error frame for err, for failures that
+// happen before a request message can be decoded and responded to in place.
+func (user *User) sendError(err error) {
+	message := &Message{Command: CommandError}
+	message.setError(err)
+	user.deliver(message)
+}
+
+// errorToWSClose maps err to the WebSocket close code that best describes
+// it and builds the close frame for it, for the few failures that are
+// fatal to the connection rather than reported in a structured frame.
+func errorToWSClose(err error) []byte {
+	var code int
+	switch err.(type) {
+	case *ProtocolError:
+		code = websocket.CloseProtocolError
+	case *PermissionError:
+		code = websocket.ClosePolicyViolation
+	default:
+		code = websocket.CloseInternalServerErr
+	}
+
+	return errorToWSCloseMessage(code, err.Error())
+}
@@ -0,0 +1,117 @@
+package gochat
+
+type Group struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	AdditionalInfo map[string]string `json:"additionalInfo,omitempty"`
+
+	users map[string]*User
+
+	registerUser   chan *User
+	unregisterUser chan *groupLeaveRequest
+
+	// roster lets other goroutines read group.users safely: it is
+	// otherwise only ever touched from the Group.Run goroutine, which
+	// isn't guarded by a lock.
+	roster chan chan []*User
+}
+
+// groupLeaveRequest asks the Group.Run goroutine to unregister user and
+// report back whether the group is now empty, so the caller can decide
+// whether to also unregister the group from its channel without racing
+// Run's own read of group.users.
+type groupLeaveRequest struct {
+	user  *User
+	empty chan bool
+}
+
+func NewGroup(id, name string, additionalInfo map[string]string) *Group {
+	return &Group{
+		ID:             id,
+		Name:           name,
+		AdditionalInfo: additionalInfo,
+
+		users: make(map[string]*User),
+
+		registerUser:   make(chan *User),
+		unregisterUser: make(chan *groupLeaveRequest),
+		roster:         make(chan chan []*User),
+	}
+}
+
+func (group *Group) Run() {
+	for {
+		select {
+		case user := <-group.registerUser:
+			group.handleRegisterUser(user)
+
+		case request := <-group.unregisterUser:
+			group.handleUnregisterUser(request.user)
+			request.empty <- len(group.users) == 0
+
+		case reply := <-group.roster:
+			reply <- group.snapshotRoster()
+		}
+	}
+}
+
+func (group *Group) handleRegisterUser(user *User) {
+	group.broadcastPresence(user, TypePresenceJoin, MessageGroupJoin)
+	group.users[user.ID] = user
+}
+
+func (group *Group) handleUnregisterUser(user *User) {
+	delete(group.users, user.ID)
+	group.broadcastPresence(user, TypePresenceLeave, MessageGroupLeave)
+}
+
+// broadcastPresence notifies the group's existing members that user joined
+// or left. It runs on the Group.Run goroutine, the same one that owns
+// group.users, so ranging over the map here is safe.
+func (group *Group) broadcastPresence(user *User, presenceType, text string) {
+	message := &Message{
+		Command: CommandPresenceUpdate,
+		User:    user,
+		Group:   group,
+		Message: &MessageInfo{Type: presenceType, Text: text},
+	}
+
+	for _, member := range group.users {
+		if member.ID == user.ID {
+			continue
+		}
+
+		member.deliver(message)
+	}
+}
+
+// Leave unregisters user from the group and reports whether the group is
+// now empty, so the caller can decide whether to also unregister the group
+// from its channel without racing Run's own read of group.users. Safe to
+// call from any goroutine: the unregister runs on the Group.Run goroutine
+// that owns group.users.
+func (group *Group) Leave(user *User) bool {
+	reply := make(chan bool)
+	group.unregisterUser <- &groupLeaveRequest{user: user, empty: reply}
+	return <-reply
+}
+
+// Roster returns a snapshot of the group's currently connected users. Safe
+// to call from any goroutine: the snapshot is built on the Group.Run
+// goroutine that owns group.users.
+func (group *Group) Roster() []*User {
+	reply := make(chan []*User)
+	group.roster <- reply
+	return <-reply
+}
+
+// snapshotRoster builds the Roster snapshot. It must only run on the
+// Group.Run goroutine.
+func (group *Group) snapshotRoster() []*User {
+	users := make([]*User, 0, len(group.users))
+	for _, user := range group.users {
+		users = append(users, user)
+	}
+
+	return users
+}
@@ -0,0 +1,436 @@
+// Package pb holds the wire types for gochat's protobuf codec.
+//
+// These types are hand-maintained rather than protoc-generated: protoc and
+// network access to fetch google.golang.org/protobuf aren't available in
+// every environment this repo is built in, so Marshal/Unmarshal implement
+// the protobuf wire format directly (see wire.go) instead of depending on
+// generated reflection code. message.proto remains the source of truth for
+// the schema and field numbers below — keep the two in sync by hand if the
+// schema changes.
+package pb
+
+import "fmt"
+
+type Message struct {
+	Command  string
+	Token    string
+	User     *User
+	Channel  *Channel
+	Group    *Group
+	Target   *Target
+	Message  *MessageInfo
+	Response *ResponseInfo
+	Users    []*User
+	Sequence uint64
+	Since    uint64
+}
+
+func (m *Message) Marshal() ([]byte, error) {
+	var w writer
+	w.string(1, m.Command)
+	w.string(2, m.Token)
+
+	if m.User != nil {
+		encoded, err := m.User.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		w.message(3, encoded)
+	}
+
+	if m.Channel != nil {
+		encoded, err := m.Channel.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		w.message(4, encoded)
+	}
+
+	if m.Group != nil {
+		encoded, err := m.Group.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		w.message(5, encoded)
+	}
+
+	if m.Target != nil {
+		encoded, err := m.Target.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		w.message(6, encoded)
+	}
+
+	if m.Message != nil {
+		encoded, err := m.Message.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		w.message(7, encoded)
+	}
+
+	if m.Response != nil {
+		encoded, err := m.Response.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		w.message(8, encoded)
+	}
+
+	for _, user := range m.Users {
+		encoded, err := user.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		w.message(9, encoded)
+	}
+
+	w.varint(10, m.Sequence)
+	w.varint(11, m.Since)
+
+	return w.buf, nil
+}
+
+func (m *Message) Unmarshal(data []byte) error {
+	*m = Message{}
+	r := reader{buf: data}
+
+	for {
+		f, ok, err := r.next()
+		if err != nil {
+			return fmt.Errorf("gochat/pb: decode Message: %w", err)
+		}
+		if !ok {
+			return nil
+		}
+
+		switch f.num {
+		case 1:
+			m.Command = string(f.bytes)
+		case 2:
+			m.Token = string(f.bytes)
+		case 3:
+			m.User = &User{}
+			if err := m.User.Unmarshal(f.bytes); err != nil {
+				return err
+			}
+		case 4:
+			m.Channel = &Channel{}
+			if err := m.Channel.Unmarshal(f.bytes); err != nil {
+				return err
+			}
+		case 5:
+			m.Group = &Group{}
+			if err := m.Group.Unmarshal(f.bytes); err != nil {
+				return err
+			}
+		case 6:
+			m.Target = &Target{}
+			if err := m.Target.Unmarshal(f.bytes); err != nil {
+				return err
+			}
+		case 7:
+			m.Message = &MessageInfo{}
+			if err := m.Message.Unmarshal(f.bytes); err != nil {
+				return err
+			}
+		case 8:
+			m.Response = &ResponseInfo{}
+			if err := m.Response.Unmarshal(f.bytes); err != nil {
+				return err
+			}
+		case 9:
+			user := &User{}
+			if err := user.Unmarshal(f.bytes); err != nil {
+				return err
+			}
+			m.Users = append(m.Users, user)
+		case 10:
+			m.Sequence = f.varint
+		case 11:
+			m.Since = f.varint
+		}
+	}
+}
+
+type User struct {
+	Id             string
+	Name           string
+	AdditionalInfo map[string]string
+	Presence       string
+	Permissions    []string
+}
+
+func (u *User) Marshal() ([]byte, error) {
+	var w writer
+	w.string(1, u.Id)
+	w.string(2, u.Name)
+	w.stringMap(3, u.AdditionalInfo)
+	w.string(4, u.Presence)
+	for _, permission := range u.Permissions {
+		w.string(5, permission)
+	}
+
+	return w.buf, nil
+}
+
+func (u *User) Unmarshal(data []byte) error {
+	*u = User{}
+	r := reader{buf: data}
+
+	for {
+		f, ok, err := r.next()
+		if err != nil {
+			return fmt.Errorf("gochat/pb: decode User: %w", err)
+		}
+		if !ok {
+			return nil
+		}
+
+		switch f.num {
+		case 1:
+			u.Id = string(f.bytes)
+		case 2:
+			u.Name = string(f.bytes)
+		case 3:
+			key, value, err := readStringMapEntry(f.bytes)
+			if err != nil {
+				return fmt.Errorf("gochat/pb: decode User.additional_info: %w", err)
+			}
+			if u.AdditionalInfo == nil {
+				u.AdditionalInfo = make(map[string]string)
+			}
+			u.AdditionalInfo[key] = value
+		case 4:
+			u.Presence = string(f.bytes)
+		case 5:
+			u.Permissions = append(u.Permissions, string(f.bytes))
+		}
+	}
+}
+
+type Channel struct {
+	Id             string
+	Name           string
+	AdditionalInfo map[string]string
+}
+
+func (c *Channel) Marshal() ([]byte, error) {
+	var w writer
+	w.string(1, c.Id)
+	w.string(2, c.Name)
+	w.stringMap(3, c.AdditionalInfo)
+
+	return w.buf, nil
+}
+
+func (c *Channel) Unmarshal(data []byte) error {
+	*c = Channel{}
+	r := reader{buf: data}
+
+	for {
+		f, ok, err := r.next()
+		if err != nil {
+			return fmt.Errorf("gochat/pb: decode Channel: %w", err)
+		}
+		if !ok {
+			return nil
+		}
+
+		switch f.num {
+		case 1:
+			c.Id = string(f.bytes)
+		case 2:
+			c.Name = string(f.bytes)
+		case 3:
+			key, value, err := readStringMapEntry(f.bytes)
+			if err != nil {
+				return fmt.Errorf("gochat/pb: decode Channel.additional_info: %w", err)
+			}
+			if c.AdditionalInfo == nil {
+				c.AdditionalInfo = make(map[string]string)
+			}
+			c.AdditionalInfo[key] = value
+		}
+	}
+}
+
+type Group struct {
+	Id             string
+	Name           string
+	AdditionalInfo map[string]string
+}
+
+func (g *Group) Marshal() ([]byte, error) {
+	var w writer
+	w.string(1, g.Id)
+	w.string(2, g.Name)
+	w.stringMap(3, g.AdditionalInfo)
+
+	return w.buf, nil
+}
+
+func (g *Group) Unmarshal(data []byte) error {
+	*g = Group{}
+	r := reader{buf: data}
+
+	for {
+		f, ok, err := r.next()
+		if err != nil {
+			return fmt.Errorf("gochat/pb: decode Group: %w", err)
+		}
+		if !ok {
+			return nil
+		}
+
+		switch f.num {
+		case 1:
+			g.Id = string(f.bytes)
+		case 2:
+			g.Name = string(f.bytes)
+		case 3:
+			key, value, err := readStringMapEntry(f.bytes)
+			if err != nil {
+				return fmt.Errorf("gochat/pb: decode Group.additional_info: %w", err)
+			}
+			if g.AdditionalInfo == nil {
+				g.AdditionalInfo = make(map[string]string)
+			}
+			g.AdditionalInfo[key] = value
+		}
+	}
+}
+
+type Target struct {
+	Type  string
+	User  *User
+	Group *Group
+}
+
+func (t *Target) Marshal() ([]byte, error) {
+	var w writer
+	w.string(1, t.Type)
+
+	if t.User != nil {
+		encoded, err := t.User.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		w.message(2, encoded)
+	}
+
+	if t.Group != nil {
+		encoded, err := t.Group.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		w.message(3, encoded)
+	}
+
+	return w.buf, nil
+}
+
+func (t *Target) Unmarshal(data []byte) error {
+	*t = Target{}
+	r := reader{buf: data}
+
+	for {
+		f, ok, err := r.next()
+		if err != nil {
+			return fmt.Errorf("gochat/pb: decode Target: %w", err)
+		}
+		if !ok {
+			return nil
+		}
+
+		switch f.num {
+		case 1:
+			t.Type = string(f.bytes)
+		case 2:
+			t.User = &User{}
+			if err := t.User.Unmarshal(f.bytes); err != nil {
+				return err
+			}
+		case 3:
+			t.Group = &Group{}
+			if err := t.Group.Unmarshal(f.bytes); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type MessageInfo struct {
+	Type string
+	Text string
+}
+
+func (i *MessageInfo) Marshal() ([]byte, error) {
+	var w writer
+	w.string(1, i.Type)
+	w.string(2, i.Text)
+
+	return w.buf, nil
+}
+
+func (i *MessageInfo) Unmarshal(data []byte) error {
+	*i = MessageInfo{}
+	r := reader{buf: data}
+
+	for {
+		f, ok, err := r.next()
+		if err != nil {
+			return fmt.Errorf("gochat/pb: decode MessageInfo: %w", err)
+		}
+		if !ok {
+			return nil
+		}
+
+		switch f.num {
+		case 1:
+			i.Type = string(f.bytes)
+		case 2:
+			i.Text = string(f.bytes)
+		}
+	}
+}
+
+type ResponseInfo struct {
+	Status  bool
+	Message string
+	Code    string
+}
+
+func (i *ResponseInfo) Marshal() ([]byte, error) {
+	var w writer
+	w.bool(1, i.Status)
+	w.string(2, i.Message)
+	w.string(3, i.Code)
+
+	return w.buf, nil
+}
+
+func (i *ResponseInfo) Unmarshal(data []byte) error {
+	*i = ResponseInfo{}
+	r := reader{buf: data}
+
+	for {
+		f, ok, err := r.next()
+		if err != nil {
+			return fmt.Errorf("gochat/pb: decode ResponseInfo: %w", err)
+		}
+		if !ok {
+			return nil
+		}
+
+		switch f.num {
+		case 1:
+			i.Status = f.varint != 0
+		case 2:
+			i.Message = string(f.bytes)
+		case 3:
+			i.Code = string(f.bytes)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package pb
+
+import "fmt"
+
+// Wire types used by the subset of the protobuf encoding the types in this
+// package need: varints and length-delimited values (strings, embedded
+// messages, and the repeated embedded entries a map field expands to).
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// writer accumulates a protobuf wire-format encoding. Each method appends a
+// tag followed by a value, skipping zero-valued fields the same way
+// protoc-generated proto3 code does, so the output matches what proto.Marshal
+// would have produced for an equivalent message.
+type writer struct {
+	buf []byte
+}
+
+func (w *writer) tag(field int, wireType int) {
+	w.buf = appendVarint(w.buf, uint64(field)<<3|uint64(wireType))
+}
+
+func (w *writer) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+
+	w.tag(field, wireVarint)
+	w.buf = appendVarint(w.buf, v)
+}
+
+func (w *writer) bool(field int, v bool) {
+	if !v {
+		return
+	}
+
+	w.tag(field, wireVarint)
+	w.buf = appendVarint(w.buf, 1)
+}
+
+func (w *writer) string(field int, v string) {
+	if v == "" {
+		return
+	}
+
+	w.tag(field, wireBytes)
+	w.buf = appendVarint(w.buf, uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// message writes encoded, the already-marshaled bytes of an embedded
+// message, as a length-delimited field.
+func (w *writer) message(field int, encoded []byte) {
+	w.tag(field, wireBytes)
+	w.buf = appendVarint(w.buf, uint64(len(encoded)))
+	w.buf = append(w.buf, encoded...)
+}
+
+// stringMap appends m as a protobuf map<string, string> field: one
+// length-delimited entry per key, each holding the key in field 1 and the
+// value in field 2, matching how protoc expands a map field.
+func (w *writer) stringMap(field int, m map[string]string) {
+	for key, value := range m {
+		var entry writer
+		entry.string(1, key)
+		entry.string(2, value)
+		w.message(field, entry.buf)
+	}
+}
+
+func appendVarint(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+
+	return append(buf, byte(v))
+}
+
+// reader walks a protobuf wire-format encoding field by field.
+type reader struct {
+	buf []byte
+}
+
+// field is one decoded (tag, value) pair. Only one of varint/bytes is
+// populated, depending on wireType.
+type field struct {
+	num      int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+// next returns the next field in the encoding, or ok == false once the
+// buffer is exhausted.
+func (r *reader) next() (field, bool, error) {
+	if len(r.buf) == 0 {
+		return field{}, false, nil
+	}
+
+	tag, n, err := readVarint(r.buf)
+	if err != nil {
+		return field{}, false, err
+	}
+	r.buf = r.buf[n:]
+
+	f := field{num: int(tag >> 3), wireType: int(tag & 0x7)}
+
+	switch f.wireType {
+	case wireVarint:
+		v, n, err := readVarint(r.buf)
+		if err != nil {
+			return field{}, false, err
+		}
+		r.buf = r.buf[n:]
+		f.varint = v
+
+	case wireBytes:
+		length, n, err := readVarint(r.buf)
+		if err != nil {
+			return field{}, false, err
+		}
+		r.buf = r.buf[n:]
+
+		if uint64(len(r.buf)) < length {
+			return field{}, false, fmt.Errorf("gochat/pb: truncated field %d", f.num)
+		}
+		f.bytes = r.buf[:length]
+		r.buf = r.buf[length:]
+
+	default:
+		return field{}, false, fmt.Errorf("gochat/pb: unsupported wire type %d for field %d", f.wireType, f.num)
+	}
+
+	return f, true, nil
+}
+
+func readVarint(buf []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(buf); i++ {
+		b := buf[i]
+		v |= uint64(b&0x7f) << (7 * i)
+		if b < 0x80 {
+			return v, i + 1, nil
+		}
+		if i == 9 {
+			return 0, 0, fmt.Errorf("gochat/pb: varint overflow")
+		}
+	}
+
+	return 0, 0, fmt.Errorf("gochat/pb: truncated varint")
+}
+
+// readStringMapEntry decodes one entry written by writer.stringMap.
+func readStringMapEntry(data []byte) (key, value string, err error) {
+	r := reader{buf: data}
+
+	for {
+		f, ok, err := r.next()
+		if err != nil {
+			return "", "", err
+		}
+		if !ok {
+			break
+		}
+
+		switch f.num {
+		case 1:
+			key = string(f.bytes)
+		case 2:
+			value = string(f.bytes)
+		}
+	}
+
+	return key, value, nil
+}
@@ -0,0 +1,96 @@
+package gochat
+
+const (
+	CommandUserConnect    = "user-connect"
+	CommandMessageSend    = "message-send"
+	CommandGroupJoin      = "group-join"
+	CommandGroupLeave     = "group-leave"
+	CommandUserList       = "user-list"
+	CommandGroupUserList  = "group-user-list"
+	CommandTypingStart    = "typing-start"
+	CommandTypingStop     = "typing-stop"
+	CommandPresenceUpdate = "presence-update"
+
+	// CommandDirectConnect opens (or resumes) a direct-message conversation
+	// with Target.User, the DM equivalent of CommandUserConnect/
+	// CommandGroupJoin: a client sends it once per conversation, not once
+	// per message, to replay history newer than Since.
+	CommandDirectConnect = "direct-connect"
+
+	// CommandError identifies a standalone error frame, sent when a
+	// failure happens before any request Command can be echoed back.
+	CommandError = "error"
+
+	TypeMessageText = "text"
+
+	TypeTargetDirect = "direct"
+	TypeTargetGroup  = "group"
+
+	MessageUserConnectSuccessful = "Connected"
+	MessageGroupJoin             = "Joined group"
+
+	ResponseMessageSuccess                = "success"
+	ResponseMessageInvalidPayload         = "invalid payload"
+	ResponseMessageUserTargetNotConnected = "target user is not connected"
+	ResponseMessagePermissionDenied       = "permission denied"
+	ResponseMessageGroupNotFound          = "group not found"
+)
+
+type (
+	MessageInfo struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+
+	ResponseInfo struct {
+		Status  bool   `json:"status"`
+		Message string `json:"message"`
+
+		// Code is one of the ErrorCode constants, set when Status is
+		// false, so clients can localize Message instead of matching on
+		// its text.
+		Code string `json:"code,omitempty"`
+	}
+
+	TargetInfo struct {
+		Type  string `json:"type"`
+		User  *User  `json:"user,omitempty"`
+		Group *Group `json:"group,omitempty"`
+	}
+
+	Message struct {
+		Command string `json:"command"`
+
+		// Token carries the signed connect credential for CommandUserConnect
+		// when the server has an Authenticator installed.
+		Token string `json:"token,omitempty"`
+
+		User    *User       `json:"user,omitempty"`
+		Channel *Channel    `json:"channel,omitempty"`
+		Group   *Group      `json:"group,omitempty"`
+		Target  *TargetInfo `json:"target,omitempty"`
+
+		Message  *MessageInfo  `json:"message,omitempty"`
+		Response *ResponseInfo `json:"response,omitempty"`
+
+		// Users carries the roster returned for CommandUserList and
+		// CommandGroupUserList requests.
+		Users []*User `json:"users,omitempty"`
+
+		// Sequence is assigned by the MessageStore when the message is
+		// appended to channel/group/direct history, so clients can track
+		// what they have already seen across reconnects.
+		Sequence uint64 `json:"sequence,omitempty"`
+
+		// Since lets a client ask to only replay history newer than a
+		// sequence number it has already processed, on connect/join/
+		// CommandDirectConnect.
+		Since uint64 `json:"since,omitempty"`
+	}
+)
+
+// encode marshals message with codec, the wire format negotiated for the
+// connection it's being sent or was received on.
+func (message *Message) encode(codec Codec) ([]byte, error) {
+	return codec.Marshal(message)
+}
@@ -0,0 +1,261 @@
+package gochat
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// defaultReplayCount is how many messages are replayed on channel connect
+// or group join when the client does not resume from a specific sequence.
+const defaultReplayCount = 50
+
+// MessageStore persists messages routed through direct and group sends so
+// they can be replayed to clients that join a group or open a direct
+// conversation, or that reconnect and resume from a sequence number they
+// have already seen. Neither send path appends under a channel's own key,
+// so the replay run on channel connect finds no messages.
+type MessageStore interface {
+	// Append stores message under key and returns the sequence number
+	// assigned to it. Sequence numbers are per-key and start at 1.
+	Append(key string, message *Message) (uint64, error)
+
+	// Replay returns, oldest first, up to limit messages stored under key
+	// with a sequence number greater than since. limit <= 0 means no cap.
+	Replay(key string, since uint64, limit int) ([]*Message, error)
+}
+
+func channelStoreKey(channelID string) string {
+	return "channel:" + channelID
+}
+
+func groupStoreKey(groupID string) string {
+	return "group:" + groupID
+}
+
+func directStoreKey(userAID, userBID string) string {
+	if userAID > userBID {
+		userAID, userBID = userBID, userAID
+	}
+
+	return "direct:" + userAID + ":" + userBID
+}
+
+type ringEntry struct {
+	sequence uint64
+	message  *Message
+}
+
+// InMemoryMessageStore keeps the last capacity messages per key in a ring
+// buffer. It is cheap and simple but does not survive a process restart.
+type InMemoryMessageStore struct {
+	mu       sync.Mutex
+	capacity int
+	nextSeq  map[string]uint64
+	entries  map[string][]ringEntry
+}
+
+func NewInMemoryMessageStore(capacity int) *InMemoryMessageStore {
+	return &InMemoryMessageStore{
+		capacity: capacity,
+		nextSeq:  make(map[string]uint64),
+		entries:  make(map[string][]ringEntry),
+	}
+}
+
+func (store *InMemoryMessageStore) Append(key string, message *Message) (uint64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.nextSeq[key]++
+	sequence := store.nextSeq[key]
+
+	entries := append(store.entries[key], ringEntry{sequence: sequence, message: message})
+	if len(entries) > store.capacity {
+		entries = entries[len(entries)-store.capacity:]
+	}
+	store.entries[key] = entries
+
+	return sequence, nil
+}
+
+func (store *InMemoryMessageStore) Replay(key string, since uint64, limit int) ([]*Message, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var messages []*Message
+	for _, entry := range store.entries[key] {
+		if entry.sequence <= since {
+			continue
+		}
+
+		messages = append(messages, entry.message)
+	}
+
+	if limit > 0 && len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+
+	return messages, nil
+}
+
+// FileMessageStore is an on-disk MessageStore. Each key gets its own
+// write-ahead log file under dir, messages appended as newline-delimited
+// JSON records, so history survives a server restart.
+type FileMessageStore struct {
+	mu      sync.Mutex
+	dir     string
+	nextSeq map[string]uint64
+}
+
+func NewFileMessageStore(dir string) (*FileMessageStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("gochat: create message store dir: %w", err)
+	}
+
+	store := &FileMessageStore{
+		dir:     dir,
+		nextSeq: make(map[string]uint64),
+	}
+
+	if err := store.loadNextSeq(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// loadNextSeq scans dir for log files left by a previous process and seeds
+// nextSeq from the highest sequence number already written under each key,
+// so sequence numbers keep incrementing across a restart instead of
+// colliding with history written before it.
+func (store *FileMessageStore) loadNextSeq() error {
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		return fmt.Errorf("gochat: list message store dir: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".log" {
+			continue
+		}
+
+		escapedKey := strings.TrimSuffix(entry.Name(), ".log")
+		key, err := url.PathUnescape(escapedKey)
+		if err != nil {
+			continue
+		}
+
+		last, err := lastSequence(filepath.Join(store.dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("gochat: scan message log for %s: %w", key, err)
+		}
+
+		store.nextSeq[key] = last
+	}
+
+	return nil
+}
+
+// lastSequence returns the highest sequence number recorded in the log
+// file at path, or 0 if it has no valid records.
+func lastSequence(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var last uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+
+		if record.Sequence > last {
+			last = record.Sequence
+		}
+	}
+
+	return last, scanner.Err()
+}
+
+type walRecord struct {
+	Sequence uint64   `json:"sequence"`
+	Message  *Message `json:"message"`
+}
+
+func (store *FileMessageStore) logPath(key string) string {
+	return filepath.Join(store.dir, url.PathEscape(key)+".log")
+}
+
+func (store *FileMessageStore) Append(key string, message *Message) (uint64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	file, err := os.OpenFile(store.logPath(key), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("gochat: open message log for %s: %w", key, err)
+	}
+	defer file.Close()
+
+	store.nextSeq[key]++
+	sequence := store.nextSeq[key]
+
+	data, err := json.Marshal(walRecord{Sequence: sequence, Message: message})
+	if err != nil {
+		return 0, fmt.Errorf("gochat: encode message log record: %w", err)
+	}
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return 0, fmt.Errorf("gochat: append message log for %s: %w", key, err)
+	}
+
+	return sequence, nil
+}
+
+func (store *FileMessageStore) Replay(key string, since uint64, limit int) ([]*Message, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	file, err := os.Open(store.logPath(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("gochat: open message log for %s: %w", key, err)
+	}
+	defer file.Close()
+
+	var messages []*Message
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+
+		if record.Sequence <= since || record.Message == nil {
+			continue
+		}
+
+		record.Message.Sequence = record.Sequence
+		messages = append(messages, record.Message)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("gochat: read message log for %s: %w", key, err)
+	}
+
+	if limit > 0 && len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,143 @@
+package gochat
+
+import "time"
+
+const (
+	PresenceOnline = "online"
+	PresenceAway   = "away"
+	PresenceBusy   = "busy"
+
+	TypePresenceJoin   = "presence-join"
+	TypePresenceLeave  = "presence-leave"
+	TypePresenceUpdate = "presence-update"
+	TypeTyping         = "typing"
+
+	MessagePresenceJoined = "joined channel"
+	MessagePresenceLeft   = "left channel"
+	MessageGroupLeave     = "Left group"
+	MessageTypingStart    = "started typing"
+	MessageTypingStop     = "stopped typing"
+
+	// minTypingInterval rate-limits how often a single user's typing-start
+	// events are broadcast, so a flaky or malicious client can't flood a
+	// channel or group with them. Typing-stop events are not rate-limited.
+	minTypingInterval = 3 * time.Second
+)
+
+func (user *User) handleUserList(message Message) {
+	message.Users = user.channel.Roster()
+	message.Response = &ResponseInfo{
+		Status:  true,
+		Message: ResponseMessageSuccess,
+	}
+
+	user.deliver(&message)
+}
+
+func (user *User) handleGroupUserList(message Message) {
+	if message.Group == nil {
+		message.setError(&UserError{Message: ResponseMessageInvalidPayload})
+
+		user.deliver(&message)
+		return
+	}
+
+	group := user.channel.findGroupByID(message.Group.ID)
+	if group == nil {
+		message.setError(&NotFoundError{Message: ResponseMessageGroupNotFound})
+
+		user.deliver(&message)
+		return
+	}
+
+	users := group.Roster()
+
+	message.Group = group
+	message.Users = users
+	message.Response = &ResponseInfo{
+		Status:  true,
+		Message: ResponseMessageSuccess,
+	}
+
+	user.deliver(&message)
+}
+
+func (user *User) handleTypingStart(message Message) {
+	if !user.allowTypingEvent() {
+		return
+	}
+
+	user.broadcastTyping(message, MessageTypingStart)
+}
+
+func (user *User) handleTypingStop(message Message) {
+	user.broadcastTyping(message, MessageTypingStop)
+}
+
+// allowTypingEvent reports whether enough time has passed since the user's
+// last broadcast typing-start event to broadcast another one.
+func (user *User) allowTypingEvent() bool {
+	now := time.Now()
+	if now.Sub(user.lastTypingAt) < minTypingInterval {
+		return false
+	}
+
+	user.lastTypingAt = now
+	return true
+}
+
+// broadcastTyping fans a typing event out to the target group's members, or
+// to the whole channel when no group target is given.
+func (user *User) broadcastTyping(message Message, text string) {
+	message.User = user
+	message.Message = &MessageInfo{Type: TypeTyping, Text: text}
+
+	if message.Target != nil && message.Target.Group != nil {
+		group := user.channel.findGroupByID(message.Target.Group.ID)
+		if group == nil {
+			return
+		}
+
+		for _, member := range group.Roster() {
+			if member.ID != user.ID {
+				member.deliver(&message)
+			}
+		}
+		return
+	}
+
+	for _, member := range user.channel.Roster() {
+		if member.ID != user.ID {
+			member.deliver(&message)
+		}
+	}
+}
+
+func (user *User) handlePresenceUpdate(message Message) {
+	if message.User == nil {
+		message.setError(&UserError{Message: ResponseMessageInvalidPayload})
+
+		user.deliver(&message)
+		return
+	}
+
+	switch message.User.Presence {
+	case PresenceOnline, PresenceAway, PresenceBusy:
+		user.Presence = message.User.Presence
+	default:
+		message.setError(&UserError{Message: ResponseMessageInvalidPayload})
+
+		user.deliver(&message)
+		return
+	}
+
+	message.User = user
+	message.Response = &ResponseInfo{
+		Status:  true,
+		Message: ResponseMessageSuccess,
+	}
+
+	for _, member := range user.channel.Roster() {
+		member.deliver(&message)
+	}
+}
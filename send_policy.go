@@ -0,0 +1,74 @@
+package gochat
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// SendPolicy controls what happens when Enqueue finds a user's send buffer
+// full, i.e. the client isn't reading fast enough to keep up.
+type SendPolicy int
+
+const (
+	// DropOldest evicts the oldest queued message to make room for the
+	// new one. It favors the sender seeing its message delivered over the
+	// slow client seeing everything.
+	DropOldest SendPolicy = iota
+
+	// DropNewest discards the message that just triggered the overflow,
+	// leaving the user's existing queue untouched.
+	DropNewest
+
+	// KickSlow closes the user's connection instead of dropping a
+	// message, so a consistently slow client is forced to reconnect
+	// rather than silently miss messages forever.
+	KickSlow
+)
+
+// Enqueue delivers payload to the user's send buffer without blocking. If
+// the buffer is full, it applies the server's SendPolicy instead of
+// stalling the caller's goroutine on a full channel.
+func (user *User) Enqueue(payload []byte) {
+	select {
+	case user.send <- payload:
+		return
+	default:
+	}
+
+	atomic.AddUint64(&user.dropped, 1)
+
+	switch user.server.sendPolicy {
+	case KickSlow:
+		log.Printf("BufferFull: kicking slow user %s", user.ID)
+
+		// Enqueue runs on whatever goroutine is delivering to this user
+		// (e.g. another user's broadcast), not user's own ReadPump/WritePump,
+		// so the disconnect is routed through closeWithError instead of
+		// calling user.conn.Close() directly here: WritePump is conn's sole
+		// writer, and closing the connection out from under it is the same
+		// hazard documented on closeWithError.
+		user.closeWithError(&UserError{Message: "send buffer full: slow consumer disconnected"})
+
+	case DropNewest:
+		log.Printf("BufferFull: dropping newest message for user %s", user.ID)
+
+	default: // DropOldest
+		log.Printf("BufferFull: dropping oldest message for user %s", user.ID)
+
+		select {
+		case <-user.send:
+		default:
+		}
+
+		select {
+		case user.send <- payload:
+		default:
+		}
+	}
+}
+
+// Dropped returns how many messages have been dropped for this user
+// because its send buffer was full.
+func (user *User) Dropped() uint64 {
+	return atomic.LoadUint64(&user.dropped)
+}
@@ -1,9 +1,23 @@
 package gochat
 
+import (
+	"log"
+	"sync"
+)
+
 type Server struct {
+	// channels, and everything handleRegisterChannel/handleUnregisterChannel
+	// do, is only ever mutated from the Run goroutine. findChannelByID is
+	// called from other goroutines (each User's ReadPump), so channelsMu
+	// guards every access to the map.
 	channels          map[string]*Channel
+	channelsMu        sync.RWMutex
 	registerChannel   chan *Channel
 	unregisterChannel chan *Channel
+	messageStore      MessageStore
+	authenticator     Authenticator
+	broker            Broker
+	sendPolicy        SendPolicy
 }
 
 func NewServer() *Server {
@@ -14,6 +28,39 @@ func NewServer() *Server {
 	}
 }
 
+// SetMessageStore installs store as the server's message history backend.
+// Messages sent through handleSendDirectMessage and handlerSendGroupMessage
+// are appended to it; group history is replayed on group join and direct
+// history on CommandDirectConnect. Channel connect replays the channel's
+// own history key, which neither send path appends to, so it replays
+// nothing. Call this before Run. A nil store (the default) disables history.
+func (server *Server) SetMessageStore(store MessageStore) {
+	server.messageStore = store
+}
+
+// SetAuthenticator installs auth as the verifier for the token a client
+// must carry on its first CommandUserConnect message. Call this before
+// Run. A nil authenticator (the default) accepts every connect message,
+// trusting its User field as-is.
+func (server *Server) SetAuthenticator(auth Authenticator) {
+	server.authenticator = auth
+}
+
+// SetBroker installs broker as the server's cross-node pub/sub backend, so
+// channels shared with other gochat processes stay in sync. Call this
+// before Run. A nil broker (the default) keeps delivery local to this
+// process.
+func (server *Server) SetBroker(broker Broker) {
+	server.broker = broker
+}
+
+// SetSendPolicy installs policy as what User.Enqueue does when a user's
+// send buffer is full, i.e. the client isn't reading fast enough. The
+// default, the zero value, is DropOldest.
+func (server *Server) SetSendPolicy(policy SendPolicy) {
+	server.sendPolicy = policy
+}
+
 func (server *Server) Run() {
 	for {
 		select {
@@ -28,6 +75,9 @@ func (server *Server) Run() {
 }
 
 func (server *Server) findChannelByID(channelID string) *Channel {
+	server.channelsMu.RLock()
+	defer server.channelsMu.RUnlock()
+
 	if channel, ok := server.channels[channelID]; ok {
 		return channel
 	}
@@ -35,12 +85,32 @@ func (server *Server) findChannelByID(channelID string) *Channel {
 	return nil
 }
 
+// handleRegisterChannel registers channel and, the first time it's seen,
+// starts its Run goroutine. subscribeBroker runs here, before Run starts,
+// so channel.inbound is fully set up before anything can select on it —
+// starting Run any earlier would race subscribeBroker's writes against
+// Run's select loop reading the same fields.
 func (server *Server) handleRegisterChannel(channel *Channel) {
-	if _, ok := server.channels[channel.ID]; !ok {
+	server.channelsMu.Lock()
+	_, exists := server.channels[channel.ID]
+	if !exists {
 		server.channels[channel.ID] = channel
 	}
+	server.channelsMu.Unlock()
+
+	if !exists {
+		if server.broker != nil {
+			if err := channel.subscribeBroker(server.broker); err != nil {
+				log.Printf("Error subscribing channel %s to broker: %s", channel.ID, err)
+			}
+		}
+
+		go channel.Run()
+	}
 }
 
 func (server *Server) handleUnregisterChannel(channel *Channel) {
+	server.channelsMu.Lock()
 	delete(server.channels, channel.ID)
-}
\ No newline at end of file
+	server.channelsMu.Unlock()
+}
@@ -1,7 +1,6 @@
 package gochat
 
 import (
-	"encoding/json"
 	"log"
 	"time"
 
@@ -19,6 +18,9 @@ const (
 	TypeUserActivityGroupLeave     = "user-group-leave"
 	TypeUserActivityMessageSend    = "user-message-send"
 	TypeUserActivityDisconnect     = "user-disconnect"
+	TypeUserActivityTypingStart    = "user-typing-start"
+	TypeUserActivityTypingStop     = "user-typing-stop"
+	TypeUserActivityPresenceUpdate = "user-presence-update"
 )
 
 var (
@@ -36,22 +38,33 @@ type (
 		ID             string            `json:"id"`
 		Name           string            `json:"name"`
 		AdditionalInfo map[string]string `json:"additionalInfo,omitempty"`
+		Presence       string            `json:"presence,omitempty"`
+		Permissions    []string          `json:"permissions,omitempty"`
 		conn           *websocket.Conn
+		codec          Codec
 		server         *Server
 		channel        *Channel
 		groups         map[string]*Group
 		send           chan []byte
+		closeFrame     chan []byte
+		done           chan struct{}
 		activity       chan *UserActivity
+		lastTypingAt   time.Time
+		authenticated  bool
+		dropped        uint64
 	}
 )
 
 func NewUser(conn *websocket.Conn, server *Server) *User {
 	return &User{
-		conn:     conn,
-		server:   server,
-		groups:   make(map[string]*Group),
-		send:     make(chan []byte, 256),
-		activity: make(chan *UserActivity),
+		conn:       conn,
+		codec:      NegotiateCodec(conn.Subprotocol()),
+		server:     server,
+		groups:     make(map[string]*Group),
+		send:       make(chan []byte, 256),
+		closeFrame: make(chan []byte, 1),
+		done:       make(chan struct{}),
+		activity:   make(chan *UserActivity),
 	}
 }
 
@@ -74,22 +87,29 @@ func (user *User) ReadPump() {
 		}
 
 		var message Message
-		if err := json.Unmarshal(jsonMessage, &message); err != nil {
-			log.Printf("Error on unmarshal JSON message %s", err)
-			return
+		if err := user.codec.Unmarshal(jsonMessage, &message); err != nil {
+			log.Printf("Error decoding message: %s", err)
+			user.sendError(&ProtocolError{Message: "malformed message"})
+			continue
 		}
 
 		switch message.Command {
 		case CommandUserConnect:
 			user.SetActivity(TypeUserActivityChannelConnect, &message)
 
-			user.handleUserConnect(message)
+			if !user.handleUserConnect(message) {
+				return
+			}
 		case CommandMessageSend:
 			user.SetActivity(TypeUserActivityMessageSend, &message)
 
 			if user.channel != nil {
 				user.handleSendMessage(message)
 			}
+		case CommandDirectConnect:
+			if user.channel != nil {
+				user.handleDirectConnect(message)
+			}
 		case CommandGroupJoin:
 			user.SetActivity(TypeUserActivityGroupJoin, &message)
 
@@ -102,18 +122,52 @@ func (user *User) ReadPump() {
 			if user.channel != nil {
 				user.handleGroupLeave(message)
 			}
+		case CommandUserList:
+			if user.channel != nil {
+				user.handleUserList(message)
+			}
+		case CommandGroupUserList:
+			if user.channel != nil {
+				user.handleGroupUserList(message)
+			}
+		case CommandTypingStart:
+			user.SetActivity(TypeUserActivityTypingStart, &message)
+
+			if user.channel != nil {
+				user.handleTypingStart(message)
+			}
+		case CommandTypingStop:
+			user.SetActivity(TypeUserActivityTypingStop, &message)
+
+			if user.channel != nil {
+				user.handleTypingStop(message)
+			}
+		case CommandPresenceUpdate:
+			user.SetActivity(TypeUserActivityPresenceUpdate, &message)
+
+			if user.channel != nil {
+				user.handlePresenceUpdate(message)
+			}
 		}
 	}
 
 }
 
 func (user *User) WritePump() {
+	// Only takes effect if the Upgrader that accepted this connection was
+	// built with EnableCompression: true and the client advertised
+	// permessage-deflate during the handshake.
+	user.conn.EnableWriteCompression(true)
+
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
 		user.conn.Close()
+		close(user.done)
 	}()
 
+	frameType := user.codec.FrameType()
+
 	for {
 		select {
 		case message, ok := <-user.send:
@@ -124,22 +178,30 @@ func (user *User) WritePump() {
 				return
 			}
 
-			w, err := user.conn.NextWriter(websocket.TextMessage)
+			w, err := user.conn.NextWriter(frameType)
 			if err != nil {
 				return
 			}
 			w.Write(message)
 
-			// Attach queued chat messages to the current websocket message.
-			n := len(user.send)
-			for i := 0; i < n; i++ {
-				w.Write(newline)
-				w.Write(<-user.send)
+			if frameType == websocket.TextMessage {
+				// Attach queued chat messages to the current websocket
+				// message. Binary codecs aren't newline-delimited, so
+				// each is sent as its own frame instead.
+				n := len(user.send)
+				for i := 0; i < n; i++ {
+					w.Write(newline)
+					w.Write(<-user.send)
+				}
 			}
 
 			if err := w.Close(); err != nil {
 				return
 			}
+		case frame := <-user.closeFrame:
+			user.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			user.conn.WriteMessage(websocket.CloseMessage, frame)
+			return
 		case <-ticker.C:
 			user.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := user.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -149,6 +211,40 @@ func (user *User) WritePump() {
 	}
 }
 
+// errorToWSCloseMessage builds a close control frame carrying a WebSocket
+// close code and reason text.
+func errorToWSCloseMessage(code int, text string) []byte {
+	return websocket.FormatCloseMessage(code, text)
+}
+
+// deliver encodes message with the user's negotiated codec and enqueues
+// it for delivery, dropping it (with a log) if encoding fails.
+func (user *User) deliver(message *Message) {
+	data, err := message.encode(user.codec)
+	if err != nil {
+		log.Printf("Error encoding message for user %s: %s", user.ID, err)
+		return
+	}
+
+	user.Enqueue(data)
+}
+
+// closeWithError asks WritePump, the connection's sole writer, to send a
+// close frame built from err and tear down the connection, then blocks
+// until it has. It must not be written to conn directly from ReadPump,
+// since WritePump writes to the same *websocket.Conn concurrently; waiting
+// for user.done here keeps a caller's own teardown (e.g. ReadPump's
+// deferred handleUserdisconnect) from closing the connection out from under
+// WritePump before the close frame actually goes out.
+func (user *User) closeWithError(err error) {
+	select {
+	case user.closeFrame <- errorToWSClose(err):
+	default:
+	}
+
+	<-user.done
+}
+
 func (user *User) GetActivity() chan *UserActivity {
 	return user.activity
 }
@@ -160,11 +256,33 @@ func (user *User) SetActivity(activityType string, message *Message) {
 	}
 }
 
-func (user *User) handleUserConnect(message Message) {
+// handleUserConnect processes a CommandUserConnect message. It returns
+// false only when the server requires a signed token and the client didn't
+// supply a valid one: the connection has then been closed and ReadPump
+// must stop reading. Other rejections, such as an invalid payload, are
+// answered with an error response and return true.
+func (user *User) handleUserConnect(message Message) bool {
+	if user.server.authenticator != nil && !user.authenticated {
+		token, err := user.server.authenticator.Authenticate(message.Token)
+		if err != nil {
+			user.closeWithError(&PermissionError{Message: "invalid or missing auth token"})
+			return false
+		}
+
+		message.User = &User{
+			ID:             token.UserID,
+			Name:           token.UserName,
+			AdditionalInfo: token.AdditionalInfo,
+		}
+		user.Permissions = token.Permissions
+		user.authenticated = true
+	}
+
 	if message.User != nil && message.Channel != nil {
 		user.ID = message.User.ID
 		user.Name = message.User.Name
 		user.AdditionalInfo = message.User.AdditionalInfo
+		user.Presence = PresenceOnline
 
 		user.channel = user.server.findChannelByID(message.Channel.ID)
 		if user.channel == nil {
@@ -175,12 +293,14 @@ func (user *User) handleUserConnect(message Message) {
 			)
 
 			user.server.registerChannel <- user.channel
-
-			go user.channel.Run()
 		}
 
 		user.channel.registerUser <- user
 
+		if user.server.messageStore != nil {
+			user.replayHistory(channelStoreKey(user.channel.ID), message.Since)
+		}
+
 		message.Message = &MessageInfo{
 			Type: TypeMessageText,
 			Text: MessageUserConnectSuccessful,
@@ -191,13 +311,12 @@ func (user *User) handleUserConnect(message Message) {
 			Message: ResponseMessageSuccess,
 		}
 	} else {
-		message.Response = &ResponseInfo{
-			Status:  false,
-			Message: ResponseMessageInvalidPayload,
-		}
+		message.setError(&UserError{Message: ResponseMessageInvalidPayload})
 	}
 
-	user.send <- []byte(message.encode())
+	user.deliver(&message)
+
+	return true
 }
 
 func (user *User) handleUserdisconnect() {
@@ -213,6 +332,13 @@ func (user *User) handleUserdisconnect() {
 
 func (user *User) handleGroupJoin(message Message) {
 	if message.Group != nil {
+		if !user.hasPermission("join:" + message.Group.ID) {
+			message.setError(&PermissionError{Message: ResponseMessagePermissionDenied})
+
+			user.deliver(&message)
+			return
+		}
+
 		group := user.channel.findGroupByID(message.Group.ID)
 		if group == nil {
 			group = NewGroup(
@@ -229,6 +355,10 @@ func (user *User) handleGroupJoin(message Message) {
 		group.registerUser <- user
 		user.groups[group.ID] = group
 
+		if user.server.messageStore != nil {
+			user.replayHistory(groupStoreKey(group.ID), message.Since)
+		}
+
 		message.User = user
 		message.Message = &MessageInfo{
 			Type: TypeMessageText,
@@ -239,18 +369,24 @@ func (user *User) handleGroupJoin(message Message) {
 			Message: ResponseMessageSuccess,
 		}
 
-		user.send <- []byte(message.encode())
+		user.deliver(&message)
 	}
 }
 
 func (user *User) handleGroupLeave(message Message) {
 	if message.Group != nil {
+		if !user.hasPermission("join:" + message.Group.ID) {
+			message.setError(&PermissionError{Message: ResponseMessagePermissionDenied})
+
+			user.deliver(&message)
+			return
+		}
+
 		group := user.channel.findGroupByID(message.Group.ID)
 		if group != nil {
 			delete(user.groups, user.ID)
-			group.unregisterUser <- user
 
-			if len(group.users) == 0 {
+			if group.Leave(user) {
 				user.channel.unregisterGroup <- group
 			}
 
@@ -261,20 +397,24 @@ func (user *User) handleGroupLeave(message Message) {
 				Message: ResponseMessageSuccess,
 			}
 
-			user.send <- []byte(message.encode())
+			user.deliver(&message)
 		}
 
 	} else {
-		message.Response = &ResponseInfo{
-			Status:  false,
-			Message: ResponseMessageInvalidPayload,
-		}
+		message.setError(&UserError{Message: ResponseMessageInvalidPayload})
 
-		user.send <- []byte(message.encode())
+		user.deliver(&message)
 	}
 }
 
 func (user *User) handleSendMessage(message Message) {
+	if !user.hasPermission("send") {
+		message.setError(&PermissionError{Message: ResponseMessagePermissionDenied})
+
+		user.deliver(&message)
+		return
+	}
+
 	if message.Message != nil && message.Target != nil {
 		switch message.Target.Type {
 		case TypeTargetDirect:
@@ -283,40 +423,105 @@ func (user *User) handleSendMessage(message Message) {
 			user.handlerSendGroupMessage(message)
 		}
 	} else {
-		message.Response = &ResponseInfo{
-			Status:  false,
-			Message: ResponseMessageInvalidPayload,
-		}
+		message.setError(&UserError{Message: ResponseMessageInvalidPayload})
+
+		user.deliver(&message)
+	}
+}
+
+// replayHistory replays messages stored under key that are newer than
+// since onto the user's send channel, oldest first.
+func (user *User) replayHistory(key string, since uint64) {
+	limit := defaultReplayCount
+	if since > 0 {
+		limit = 0
+	}
 
-		user.send <- []byte(message.encode())
+	messages, err := user.server.messageStore.Replay(key, since, limit)
+	if err != nil {
+		log.Printf("Error replaying message history for %s: %s", key, err)
+		return
 	}
+
+	for _, replayed := range messages {
+		user.deliver(replayed)
+	}
+}
+
+// handleDirectConnect replays direct-message history with message.Target.User
+// newer than message.Since, the CommandDirectConnect counterpart to
+// handleUserConnect/handleGroupJoin's channel/group replay. Unlike those, it
+// doesn't register user anywhere: a direct-message conversation has no
+// membership to join, so this only runs the replay side-effect.
+func (user *User) handleDirectConnect(message Message) {
+	if message.Target == nil || message.Target.User == nil {
+		message.setError(&UserError{Message: ResponseMessageInvalidPayload})
+
+		user.deliver(&message)
+		return
+	}
+
+	if user.server.messageStore != nil {
+		user.replayHistory(directStoreKey(user.ID, message.Target.User.ID), message.Since)
+	}
+
+	message.Response = &ResponseInfo{
+		Status:  true,
+		Message: ResponseMessageSuccess,
+	}
+
+	user.deliver(&message)
 }
 
+// handleSendDirectMessage sends message to its direct target. The target
+// doesn't have to be connected to this node: when a broker is configured,
+// the message is published to the channel topic unconditionally and it's
+// handleBrokerMessage's TypeTargetDirect branch, running on whichever node
+// the recipient is actually connected to, that resolves them against its
+// own local channel.users and delivers. Without a broker, delivery can only
+// ever be local, so the target must be found here or the send fails.
 func (user *User) handleSendDirectMessage(message Message) {
-	if message.Target.User != nil {
-		userTarget := user.channel.findUserByID(message.Target.User.ID)
-		if userTarget == nil {
-			message.Response = &ResponseInfo{
-				Status:  false,
-				Message: ResponseMessageUserTargetNotConnected,
-			}
+	if message.Target.User == nil {
+		return
+	}
 
-			message.User = user
+	userTarget := user.channel.findUserByID(message.Target.User.ID)
+	if userTarget == nil && user.channel.broker == nil {
+		message.setError(&NotFoundError{Message: ResponseMessageUserTargetNotConnected})
 
-			user.send <- []byte(message.encode())
-		} else {
-			userTarget.send <- []byte(message.encode())
+		message.User = user
 
-			message.User = user
-			message.Target.User = userTarget
-			message.Response = &ResponseInfo{
-				Status:  true,
-				Message: ResponseMessageSuccess,
-			}
+		user.deliver(&message)
+		return
+	}
+
+	message.User = user
+	if userTarget != nil {
+		message.Target.User = userTarget
+	}
 
-			user.send <- []byte(message.encode())
+	if user.server.messageStore != nil {
+		sequence, err := user.server.messageStore.Append(directStoreKey(user.ID, message.Target.User.ID), &message)
+		if err != nil {
+			log.Printf("Error appending direct message to store: %s", err)
+		}
+		message.Sequence = sequence
+	}
+
+	if user.channel.broker != nil {
+		if err := user.channel.publish(&message); err != nil {
+			log.Printf("Error publishing direct message: %s", err)
 		}
+	} else {
+		userTarget.deliver(&message)
+	}
+
+	message.Response = &ResponseInfo{
+		Status:  true,
+		Message: ResponseMessageSuccess,
 	}
+
+	user.deliver(&message)
 }
 
 func (user *User) handlerSendGroupMessage(message Message) {
@@ -326,9 +531,23 @@ func (user *User) handlerSendGroupMessage(message Message) {
 			message.User = user
 			message.Target.Group = groupTarget
 
-			for _, userGroupTarget := range groupTarget.users {
-				if userGroupTarget.ID != user.ID {
-					userGroupTarget.send <- []byte(message.encode())
+			if user.server.messageStore != nil {
+				sequence, err := user.server.messageStore.Append(groupStoreKey(groupTarget.ID), &message)
+				if err != nil {
+					log.Printf("Error appending group message to store: %s", err)
+				}
+				message.Sequence = sequence
+			}
+
+			if user.channel.broker != nil {
+				if err := user.channel.publish(&message); err != nil {
+					log.Printf("Error publishing group message: %s", err)
+				}
+			} else {
+				for _, userGroupTarget := range groupTarget.Roster() {
+					if userGroupTarget.ID != user.ID {
+						userGroupTarget.deliver(&message)
+					}
 				}
 			}
 
@@ -337,7 +556,7 @@ func (user *User) handlerSendGroupMessage(message Message) {
 				Message: ResponseMessageSuccess,
 			}
 
-			user.send <- []byte(message.encode())
+			user.deliver(&message)
 		}
 	}
 }